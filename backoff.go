@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff produces jittered exponential delays for the reconnect
+// supervisor. The delay is capped at max before jitter is applied, so a
+// returned delay can exceed max by up to 20%.
+type backoff struct {
+	base    time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+// newBackoff 创建一个从 base 开始、以 max 为上限的指数退避计算器
+func newBackoff(base, max time.Duration) *backoff {
+	return &backoff{base: base, max: max, current: base}
+}
+
+// Next returns the next delay to wait, doubling current (capped at
+// max) and applying ±20% jitter to avoid a reconnect thundering herd.
+func (b *backoff) Next() time.Duration {
+	delay := b.current
+
+	b.current *= 2
+	if b.current > b.max {
+		b.current = b.max
+	}
+
+	jitter := time.Duration(float64(delay) * (rand.Float64()*0.4 - 0.2))
+	delay += jitter
+	if delay < 0 {
+		delay = b.base
+	}
+	return delay
+}
+
+// Reset returns the backoff to its initial delay.
+func (b *backoff) Reset() {
+	b.current = b.base
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffNextGrowsAndCaps(t *testing.T) {
+	b := newBackoff(time.Second, 8*time.Second)
+
+	// jitter is ±20%, so bound each step generously rather than
+	// asserting an exact value.
+	bounds := []struct{ min, max time.Duration }{
+		{800 * time.Millisecond, 1200 * time.Millisecond},
+		{1600 * time.Millisecond, 2400 * time.Millisecond},
+		{3200 * time.Millisecond, 4800 * time.Millisecond},
+		{6400 * time.Millisecond, 9600 * time.Millisecond}, // jitter is applied after the cap, so this can exceed max
+	}
+
+	for i, want := range bounds {
+		got := b.Next()
+		if got < want.min || got > want.max {
+			t.Fatalf("step %d: Next() = %v, want within [%v, %v]", i, got, want.min, want.max)
+		}
+	}
+
+	// Once current has capped, repeated calls stay within ±20% of max
+	// even though current itself never grows past it.
+	for i := 0; i < 5; i++ {
+		if got := b.Next(); got > 9600*time.Millisecond {
+			t.Fatalf("Next() = %v, want <= max+20%% (9.6s) once capped", got)
+		}
+	}
+}
+
+func TestBackoffReset(t *testing.T) {
+	b := newBackoff(time.Second, time.Minute)
+	b.Next()
+	b.Next()
+	b.Reset()
+
+	got := b.Next()
+	if got < 800*time.Millisecond || got > 1200*time.Millisecond {
+		t.Fatalf("Next() after Reset = %v, want within ±20%% of base 1s", got)
+	}
+}
@@ -3,134 +3,545 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 	"lcf-controller/inject"
 	"lcf-controller/logger"
 	"lcf-controller/net/server"
+	"lcf-controller/pkg/actions"
+	"lcf-controller/pkg/auth"
 	"lcf-controller/pkg/config"
 	"lcf-controller/pkg/info"
 	_type "lcf-controller/pkg/type/frps"
+	"lcf-controller/pkg/transport"
+	"lcf-controller/tunnel"
 	"os"
 	"os/signal"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
+// proxyStatsWorkers bounds how many upload-proxy-stats frames are in
+// flight at once when falling back to per-proxy sends.
+const proxyStatsWorkers = 4
+
+// batchProtocolVersion is the lowest server protocol version that
+// understands the upload-proxy-stats-batch action.
+const batchProtocolVersion = 2
+
+// reconnectBaseDelay and reconnectMaxDelay bound the jittered
+// exponential backoff used while the connection to the server is down.
+const reconnectBaseDelay = time.Second
+const reconnectMaxDelay = 60 * time.Second
+
+// actionTimeout bounds how long a single dispatched action may run.
+const actionTimeout = 30 * time.Second
+
 // NewWebSocket 初始化WebSocket客户端
 func NewWebSocket() *WsClient {
 	ws := new(WsClient)
 	cfg := config.ReadCfg()
 	ws.addr = cfg.ControllerConfig.Addr
+	ws.allow = tunnelAllowList(cfg)
+	ws.outbox = newOutbox()
+	ws.auth = auth.NewManager(cfg.ControllerConfig.AuthUrl, cfg.ControllerConfig.NodeId, cfg.ControllerConfig.NodeApiKey)
+	ws.actions = newActionRegistry(ws)
 	return ws
 }
 
-// ConnectWsServer 连接到WebSocket服务器
+// newActionRegistry builds the table of server-pushed actions this node knows how to handle.
+func newActionRegistry(ws *WsClient) *actions.Registry {
+	reg := actions.NewRegistry()
+	reg.Register("reload-frps-config", ws.handleReloadFrpsConfig)
+	reg.Register("restart-frps", ws.handleRestartFrps)
+	reg.Register("kick-proxy", ws.handleKickProxy)
+	reg.Register("collect-logs", ws.handleCollectLogs)
+	reg.Register("run-opengfw-rule-update", ws.handleOpenGFWRuleUpdate)
+	reg.Register("open-tunnel", ws.handleOpenTunnelAction)
+	reg.Register("close-tunnel", ws.handleCloseTunnelAction)
+	return reg
+}
+
+// tunnelAllowList converts the operator-configured tunnel allow-list
+// into the shape the tunnel package expects.
+func tunnelAllowList(cfg *config.Config) []tunnel.AllowEntry {
+	entries := make([]tunnel.AllowEntry, 0, len(cfg.ControllerConfig.TunnelAllowlist))
+	for _, e := range cfg.ControllerConfig.TunnelAllowlist {
+		entries = append(entries, tunnel.AllowEntry{
+			Host:     e.Host,
+			Port:     e.Port,
+			Protocol: e.Protocol,
+		})
+	}
+	return entries
+}
+
+// rawTransport is implemented by transports that can also carry the
+// binary tunnel framing (today, only WebSocket).
+type rawTransport interface {
+	ReadRaw() (messageType int, data []byte, err error)
+	WriteRaw(messageType int, data []byte) error
+}
+
+// ConnectWsServer 连接到服务器，并根据地址协议选择对应的传输方式
 func (w *WsClient) ConnectWsServer() (err error) {
-	conn, _, err := websocket.DefaultDialer.Dial(w.addr, nil)
+	t, err := transport.Dial(w.addr)
 	if err != nil {
 		return err
 	}
-	w.conn = conn
+	w.setTransport(t)
+
+	if raw, ok := t.(rawTransport); ok {
+		w.mux = tunnel.NewMux(raw, w.allow)
+	} else {
+		w.mux = nil
+		logger.Debug("transport doesn't support raw frames, tunnel subsystem disabled")
+	}
 	return nil
 }
 
-// SendMsg 发送消息到服务器
-func (w *WsClient) SendMsg(cfg *config.Config, action string, data map[string]any) (err error) {
-	req := new(BasicRequest)
-	req.Action = action
-	req.Node.Id = cfg.ControllerConfig.NodeId
-	req.Node.ApiKey = cfg.ControllerConfig.NodeApiKey
-	req.Data = data
-	msg, err := json.Marshal(req)
-	if err := w.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-		return err
+// setTransport and currentTransport guard w.transport against the
+// outgoing session's goroutines still running when Run reconnects.
+func (w *WsClient) setTransport(t transport.Transport) {
+	w.transportMu.Lock()
+	defer w.transportMu.Unlock()
+	w.transport = t
+}
+
+func (w *WsClient) currentTransport() transport.Transport {
+	w.transportMu.RLock()
+	defer w.transportMu.RUnlock()
+	return w.transport
+}
+
+// Run supervises the connection to the panel for as long as ctx is
+// alive: it dials, runs one session to completion, and dials again.
+// A failed dial waits out a jittered exponential backoff before the
+// next attempt; after a session ends, it redials at once.
+func (w *WsClient) Run(ctx context.Context, cfg *config.Config) {
+	if err := w.auth.Refresh(ctx); err != nil {
+		logger.Error("can't fetch initial node token", zap.Error(err))
 	}
+	go w.auth.Run(ctx)
 
-	return nil
+	bo := newBackoff(reconnectBaseDelay, reconnectMaxDelay)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if err := w.ConnectWsServer(); err != nil {
+			delay := bo.Next()
+			logger.Error("can't connect to controller server, retrying", zap.Error(err), zap.Duration("in", delay))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			continue
+		}
+
+		logger.Info("connect to controller server successfully")
+		bo.Reset()
+		w.runSession(ctx, cfg)
+	}
 }
 
-// ReadMsg 从服务器读取消息
-func (w *WsClient) ReadMsg() {
-	defer func() {
-		err := w.conn.Close()
+// runSession drives one connected session until either the reader or
+// the writer gives up on the connection.
+func (w *WsClient) runSession(ctx context.Context, cfg *config.Config) {
+	sessionCtx, cancel := context.WithCancel(ctx)
+
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		w.ReadMsg(sessionCtx, cfg)
+		cancel()
+	}()
+
+	w.writeLoop(sessionCtx, cfg)
+	cancel()
+
+	if err := w.currentTransport().Close(); err != nil {
+		logger.Error("error closing connection", zap.Error(err))
+	}
+	<-readDone
+
+	// Wait out this session's dispatchAction goroutines before Run dials again.
+	w.actionWG.Wait()
+}
+
+// writeLoop owns every outbound message for the session: it
+// periodically collects fresh stats into the outbox and flushes it to
+// the server, returning as soon as a send fails or ctx is cancelled.
+func (w *WsClient) writeLoop(ctx context.Context, cfg *config.Config) {
+	collect := func() {
+		serverInfo, err := server.GetServerInfo()
 		if err != nil {
-			logger.Error("error closing connection", zap.Error(err))
+			logger.Error("can't get server info", zap.Error(err))
+			return
 		}
-	}()
+		w.queueNodeStats(serverInfo)
+		w.queueProxyStats()
+	}
+
+	statsTicker := time.NewTicker(cfg.ControllerConfig.SendDuration)
+	defer statsTicker.Stop()
 
+	collect()
 	for {
-		_, msg, err := w.conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				logger.Error("error reading message", zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-statsTicker.C:
+			collect()
+		case <-w.outbox.wake:
+			sendNode := func(action string, data map[string]any) error {
+				return w.SendMsg(ctx, cfg, action, data)
+			}
+			sendProxies := func(stats []map[string]any) error {
+				return w.sendProxyStats(ctx, cfg, stats)
+			}
+			if err := w.outbox.Drain(sendNode, sendProxies); err != nil {
+				logger.Error("can't flush outbox to server", zap.Error(err))
+				return
 			}
-			break
 		}
-		var msgJson WsResponse
-		if err := json.Unmarshal(msg, &msgJson); err != nil {
-		logger.Error("can't unmarshal json message", zap.Error(err))
-		continue
 	}
+}
+
+// SendMsg 发送消息到服务器
+func (w *WsClient) SendMsg(ctx context.Context, cfg *config.Config, action string, data map[string]any) (err error) {
+	req := transport.Request{
+		Action: action,
+		Node:   transport.NodeInfo{Id: cfg.ControllerConfig.NodeId},
+		Token:  w.auth.Current().Value,
+		Data:   data,
+	}
+	return w.currentTransport().Send(ctx, req)
+}
+
+// ReadMsg 从服务器读取消息
+func (w *WsClient) ReadMsg(ctx context.Context, cfg *config.Config) {
+	if w.mux != nil {
+		defer w.mux.CloseAll()
+	}
+
+	raw, isRaw := w.currentTransport().(rawTransport)
+	for {
+		var (
+			msgJson transport.Message
+			err     error
+		)
+
+		if isRaw {
+			var mt int
+			var data []byte
+			mt, data, err = raw.ReadRaw()
+			if err != nil {
+				logger.Error("error reading message", zap.Error(err))
+				return
+			}
+			if mt == websocket.BinaryMessage {
+				if err := w.mux.HandleFrame(data); err != nil {
+					logger.Error("can't handle tunnel frame", zap.Error(err))
+				}
+				continue
+			}
+			if err := json.Unmarshal(data, &msgJson); err != nil {
+				logger.Error("can't unmarshal json message", zap.Error(err))
+				continue
+			}
+		} else {
+			msgJson, err = w.currentTransport().Recv(ctx)
+			if err != nil {
+				logger.Error("error reading message", zap.Error(err))
+				return
+			}
+		}
+
+		if msgJson.ProtocolVersion != 0 {
+			w.serverProtocolVersion.Store(int32(msgJson.ProtocolVersion))
+		}
+
+		if msgJson.Action != "" {
+			w.actionWG.Add(1)
+			go func() {
+				defer w.actionWG.Done()
+				w.dispatchAction(ctx, cfg, msgJson)
+			}()
+			continue
+		}
+
 		switch msgJson.Status {
 		case 200:
-			logger.Debug("received message from server", zap.String("msg", string(msg)))
+			logger.Debug("received message from server", zap.Int("status", msgJson.Status))
 		case 401:
-			logger.Fatal("API reported unauthorized")
+			logger.Error("server rejected node token as unauthorized, refreshing")
+			if err := w.auth.Refresh(ctx); err != nil {
+				logger.Error("can't refresh node token", zap.Error(err))
+			}
 		default:
-			logger.Error("error message from server", zap.String("msg", string(msg)))
+			logger.Error("error message from server", zap.Int("status", msgJson.Status), zap.String("message", msgJson.Message))
 		}
 	}
 }
 
-func (w *WsClient) sendNodeStatsToServer(cfg *config.Config, serverInfo _type.ServerInfoResponse) {
-	// nodeInfo
-	err := w.SendMsg(cfg, "upload-node-stats", info.GetNodeInfo(serverInfo))
+// dispatchAction runs the handler registered for a server-pushed
+// action and reports the outcome back as a correlation-ID'd action-reply frame.
+// actionTimeout is enforced here, not just offered to the handler as a
+// cancellable context: a handler that ignores ctx (ignoring it is a bug,
+// not a contract) would otherwise hang dispatchAction, and with it the
+// actionWG a reconnect waits on, past the timeout it's documented to honor.
+func (w *WsClient) dispatchAction(ctx context.Context, cfg *config.Config, msg transport.Message) {
+	actionCtx, cancel := context.WithTimeout(ctx, actionTimeout)
+	defer cancel()
+
+	type outcome struct {
+		result any
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := w.actions.Dispatch(actionCtx, msg.Action, msg.Data)
+		done <- outcome{result, err}
+	}()
+
+	var result any
+	var err error
+	select {
+	case o := <-done:
+		result, err = o.result, o.err
+	case <-actionCtx.Done():
+		err = fmt.Errorf("action %q did not complete within %s", msg.Action, actionTimeout)
+	}
+
+	reply := map[string]any{
+		"correlation_id": msg.CorrelationId,
+		"action":         msg.Action,
+		"ok":             err == nil,
+	}
+	if err != nil {
+		var unknown *actions.UnknownActionError
+		if errors.As(err, &unknown) {
+			logger.Error("server pushed an unsupported action", zap.String("action", msg.Action))
+		} else {
+			logger.Error("action handler failed", zap.String("action", msg.Action), zap.Error(err))
+		}
+		reply["error"] = err.Error()
+	} else {
+		reply["result"] = result
+	}
+
+	if err := w.SendMsg(ctx, cfg, "action-reply", reply); err != nil {
+		logger.Error("can't send action reply", zap.String("action", msg.Action), zap.Error(err))
+	}
+}
+
+// openTunnelRequest is the payload of a server-issued open-tunnel action.
+type openTunnelRequest struct {
+	StreamId   uint32 `json:"stream_id"`
+	Protocol   string `json:"protocol"`
+	TargetHost string `json:"target_host"`
+	TargetPort int    `json:"target_port"`
+}
+
+// closeTunnelRequest identifies the stream a close-tunnel action tears down.
+type closeTunnelRequest struct {
+	StreamId uint32 `json:"stream_id"`
+}
+
+// handleOpenTunnelAction dials the requested target and starts
+// bridging it onto the stream ID the server chose.
+func (w *WsClient) handleOpenTunnelAction(ctx context.Context, data json.RawMessage) (any, error) {
+	if w.mux == nil {
+		return nil, fmt.Errorf("open-tunnel requested but active transport doesn't support tunnels")
+	}
+	var req openTunnelRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("can't unmarshal open-tunnel request: %w", err)
+	}
+	if err := w.mux.Open(ctx, req.StreamId, req.Protocol, req.TargetHost, req.TargetPort); err != nil {
+		return nil, fmt.Errorf("can't open tunnel for stream %d: %w", req.StreamId, err)
+	}
+	return nil, nil
+}
+
+// handleCloseTunnelAction tears down the stream named by a
+// close-tunnel action.
+func (w *WsClient) handleCloseTunnelAction(_ context.Context, data json.RawMessage) (any, error) {
+	if w.mux == nil {
+		return nil, nil
+	}
+	var req closeTunnelRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("can't unmarshal close-tunnel request: %w", err)
+	}
+	w.mux.Close(req.StreamId)
+	return nil, nil
+}
+
+// kickProxyRequest names the proxy a kick-proxy action should forcibly disconnect.
+type kickProxyRequest struct {
+	ProxyName string `json:"proxy_name"`
+}
+
+// openGFWRuleUpdateRequest points at the rule set a run-opengfw-rule-update action should apply.
+type openGFWRuleUpdateRequest struct {
+	RuleUrl string `json:"rule_url"`
+}
+
+// handleReloadFrpsConfig reloads the local frps configuration without
+// restarting the process.
+func (w *WsClient) handleReloadFrpsConfig(_ context.Context, _ json.RawMessage) (any, error) {
+	return nil, server.ReloadConfig()
+}
+
+// handleRestartFrps restarts the local frps process.
+func (w *WsClient) handleRestartFrps(_ context.Context, _ json.RawMessage) (any, error) {
+	return nil, server.Restart()
+}
+
+// handleKickProxy forcibly disconnects the named proxy.
+func (w *WsClient) handleKickProxy(_ context.Context, data json.RawMessage) (any, error) {
+	var req kickProxyRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("can't unmarshal kick-proxy request: %w", err)
+	}
+	return nil, server.KickProxy(req.ProxyName)
+}
+
+// handleCollectLogs gathers the node's recent logs for the panel to pull on demand.
+func (w *WsClient) handleCollectLogs(_ context.Context, _ json.RawMessage) (any, error) {
+	logs, err := server.CollectLogs()
 	if err != nil {
-		logger.Error("send node info to server failed!", zap.Error(err))
+		return nil, err
 	}
+	return map[string]any{"logs": logs}, nil
 }
 
-func (w *WsClient) sendProxyStatsToServer(cfg *config.Config) {
+// handleOpenGFWRuleUpdate fetches and applies the OpenGFW rule set the
+// panel points at.
+func (w *WsClient) handleOpenGFWRuleUpdate(ctx context.Context, data json.RawMessage) (any, error) {
+	var req openGFWRuleUpdateRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("can't unmarshal run-opengfw-rule-update request: %w", err)
+	}
+	return nil, inject.UpdateOpenGFWRules(ctx, req.RuleUrl)
+}
+
+// queueNodeStats buffers the latest node stats snapshot for the
+// write loop to flush; it replaces any snapshot still waiting to be
+// sent, since only the most recent one matters.
+func (w *WsClient) queueNodeStats(serverInfo _type.ServerInfoResponse) {
+	w.outbox.PutNodeStats(info.GetNodeInfo(serverInfo))
+}
+
+// queueProxyStats fetches every proxy protocol type in parallel and
+// buffers the results, deduplicated by proxy name, for the write loop
+// to flush.
+func (w *WsClient) queueProxyStats() {
 	types := []string{"tcp", "udp", "http", "https", "xtcp", "stcp"}
+
+	var g errgroup.Group
 	for _, p := range types {
-		proxies, err := info.GetProxies(p)
-		if err != nil {
-			logger.Error("can't request proxies info", zap.Error(err))
-		} else {
+		protocol := p
+		g.Go(func() error {
+			proxies, err := info.GetProxies(protocol)
+			if err != nil {
+				logger.Error("can't request proxies info", zap.String("protocol", protocol), zap.Error(err))
+				return nil
+			}
 			for _, j := range proxies {
-				err := w.SendMsg(cfg, "upload-proxy-stats", j)
-				logger.Info("send proxy info to the server")
-				if err != nil {
-					logger.Error("send proxy info to server failed!", zap.Error(err))
+				name, _ := j["name"].(string)
+				w.outbox.PutProxyStats(name, j)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
+
+// sendProxyStats ships a batch of proxy stats to the server, either as
+// one upload-proxy-stats-batch frame or, for older servers, fanned out
+// over sendProxyStatsFanout.
+func (w *WsClient) sendProxyStats(ctx context.Context, cfg *config.Config, stats []map[string]any) error {
+	if w.supportsBatch() {
+		return w.SendMsg(ctx, cfg, "upload-proxy-stats-batch", map[string]any{"proxies": stats})
+	}
+	return w.sendProxyStatsFanout(ctx, cfg, stats)
+}
+
+// sendProxyStatsFanout sends one upload-proxy-stats frame per proxy
+// across proxyStatsWorkers goroutines, returning the first error seen.
+func (w *WsClient) sendProxyStatsFanout(ctx context.Context, cfg *config.Config, stats []map[string]any) error {
+	jobs := make(chan map[string]any)
+	errCh := make(chan error, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < proxyStatsWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for data := range jobs {
+				if err := w.SendMsg(ctx, cfg, "upload-proxy-stats", data); err != nil {
+					select {
+					case errCh <- err:
+					default:
+					}
 				}
 			}
-		}
+		}()
+	}
+
+	for _, s := range stats {
+		jobs <- s
+	}
+	close(jobs)
+	wg.Wait()
+
+	select {
+	case err := <-errCh:
+		return err
+	default:
+		return nil
 	}
 }
 
-// WsClient WebSocket客户端结构
+// WsClient 控制器客户端结构，通过可插拔的 Transport 与面板通信
 type WsClient struct {
 	addr string
-	conn *websocket.Conn
-}
 
-type WsResponse struct {
-	Status  int             `json:"status"`
-	Message string          `json:"message"`
-	Data    json.RawMessage `json:"data"`
-}
+	transportMu sync.RWMutex
+	transport   transport.Transport
+
+	mux     *tunnel.Mux
+	allow   []tunnel.AllowEntry
+	outbox  *outbox
+	auth    *auth.Manager
+	actions *actions.Registry
 
-type BasicRequest struct {
-	Action string         `json:"action"`
-	Node   NodeInfo       `json:"node"`
-	Data   map[string]any `json:"data"`
+	// actionWG tracks in-flight dispatchAction goroutines.
+	actionWG sync.WaitGroup
+
+	// serverProtocolVersion is updated from the server's responses; 0
+	// means unadvertised, which is treated as pre-batch.
+	serverProtocolVersion atomic.Int32
 }
 
-type NodeInfo struct {
-	Id     int    `json:"id"`
-	ApiKey string `json:"api_key"`
+// supportsBatch reports whether the connected server understands the
+// upload-proxy-stats-batch action.
+func (w *WsClient) supportsBatch() bool {
+	return w.serverProtocolVersion.Load() >= batchProtocolVersion
 }
 
 func createContext() (context.Context, context.CancelFunc) {
@@ -170,45 +581,8 @@ func main() {
 
 	if cfg.ControllerConfig.Enable {
 		ws := NewWebSocket()
-		logger.Info("connecting to WebSocket endpoint...")
-		err := ws.ConnectWsServer()
-		if err != nil {
-			logger.Fatal(
-				"can't connect to WebSocket server",
-				zap.Error(err),
-			)
-		} else {
-			logger.Info("connect to WebSocket server successfully")
-			defer func(conn *websocket.Conn) {
-				err := conn.Close()
-				if err != nil {
-					logger.Fatal(
-						"can't close WebSocket connection",
-						zap.Error(err),
-					)
-				}
-			}(ws.conn)
-			go ws.ReadMsg()
-			ticker := time.NewTicker(cfg.ControllerConfig.SendDuration)
-			defer ticker.Stop()
-
-			serverInfo, err := server.GetServerInfo()
-			if err != nil {
-				logger.Error("can't get server info", zap.Error(err))
-			} else {
-				ws.sendNodeStatsToServer(cfg, serverInfo)
-				ws.sendProxyStatsToServer(cfg)
-			}
-
-			for range ticker.C {
-				if err != nil {
-					logger.Error("can't get server info", zap.Error(err))
-				} else {
-					ws.sendNodeStatsToServer(cfg, serverInfo)
-					ws.sendProxyStatsToServer(cfg)
-				}
-			}
-		}
+		logger.Info("connecting to controller endpoint...")
+		ws.Run(ctx, cfg)
 	} else {
 		ticker := time.NewTicker(cfg.ControllerConfig.SendDuration)
 		defer ticker.Stop()
@@ -0,0 +1,83 @@
+package main
+
+import "sync"
+
+// pendingMsg is one message waiting to be flushed to the server.
+type pendingMsg struct {
+	action string
+	data   map[string]any
+}
+
+// outbox buffers stats collected while the WebSocket is down or busy.
+// It keeps only the latest node-stats snapshot and the latest
+// per-proxy snapshot, so a reconnect replays current state instead of
+// a backlog of stale duplicates.
+type outbox struct {
+	mu         sync.Mutex
+	nodeStat   *pendingMsg
+	proxyStats map[string]map[string]any
+
+	wake chan struct{}
+}
+
+// newOutbox 创建一个用于离线缓冲与去重的发送队列
+func newOutbox() *outbox {
+	return &outbox{
+		proxyStats: make(map[string]map[string]any),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// PutNodeStats replaces any previously buffered node-stats snapshot.
+func (o *outbox) PutNodeStats(data map[string]any) {
+	o.mu.Lock()
+	o.nodeStat = &pendingMsg{action: "upload-node-stats", data: data}
+	o.mu.Unlock()
+	o.notify()
+}
+
+// PutProxyStats replaces any previously buffered snapshot for the
+// named proxy. Safe for concurrent use.
+func (o *outbox) PutProxyStats(proxyName string, data map[string]any) {
+	o.mu.Lock()
+	o.proxyStats[proxyName] = data
+	o.mu.Unlock()
+	o.notify()
+}
+
+// notify wakes the drain loop without blocking if it's already awake.
+func (o *outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Drain empties the outbox, handing the node-stats snapshot to
+// sendNode and every buffered proxy snapshot, as a single slice, to
+// sendProxies. It stops and returns on the first error.
+func (o *outbox) Drain(sendNode func(action string, data map[string]any) error, sendProxies func(stats []map[string]any) error) error {
+	o.mu.Lock()
+	node := o.nodeStat
+	o.nodeStat = nil
+	proxies := o.proxyStats
+	o.proxyStats = make(map[string]map[string]any)
+	o.mu.Unlock()
+
+	if node != nil {
+		if err := sendNode(node.action, node.data); err != nil {
+			return err
+		}
+	}
+
+	if len(proxies) > 0 {
+		stats := make([]map[string]any, 0, len(proxies))
+		for _, p := range proxies {
+			stats = append(stats, p)
+		}
+		if err := sendProxies(stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestOutboxPutNodeStatsReplaces(t *testing.T) {
+	o := newOutbox()
+	o.PutNodeStats(map[string]any{"cpu": 1})
+	o.PutNodeStats(map[string]any{"cpu": 2})
+
+	var gotNode map[string]any
+	err := o.Drain(
+		func(action string, data map[string]any) error {
+			if action != "upload-node-stats" {
+				t.Fatalf("action = %q, want upload-node-stats", action)
+			}
+			gotNode = data
+			return nil
+		},
+		func(stats []map[string]any) error { return nil },
+	)
+	if err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	if gotNode["cpu"] != 2 {
+		t.Fatalf("gotNode[cpu] = %v, want 2 (latest snapshot should win)", gotNode["cpu"])
+	}
+}
+
+func TestOutboxPutProxyStatsDedupsByName(t *testing.T) {
+	o := newOutbox()
+	o.PutProxyStats("p1", map[string]any{"traffic": 1})
+	o.PutProxyStats("p1", map[string]any{"traffic": 2})
+	o.PutProxyStats("p2", map[string]any{"traffic": 3})
+
+	var gotStats []map[string]any
+	err := o.Drain(
+		func(action string, data map[string]any) error { return nil },
+		func(stats []map[string]any) error {
+			gotStats = stats
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	if len(gotStats) != 2 {
+		t.Fatalf("len(gotStats) = %d, want 2 (duplicate p1 update should collapse)", len(gotStats))
+	}
+}
+
+func TestOutboxDrainStopsOnFirstError(t *testing.T) {
+	o := newOutbox()
+	o.PutNodeStats(map[string]any{"cpu": 1})
+	o.PutProxyStats("p1", map[string]any{"traffic": 1})
+
+	proxiesCalled := false
+	err := o.Drain(
+		func(action string, data map[string]any) error { return errFakeSend },
+		func(stats []map[string]any) error {
+			proxiesCalled = true
+			return nil
+		},
+	)
+	if err != errFakeSend {
+		t.Fatalf("Drain() error = %v, want errFakeSend", err)
+	}
+	if proxiesCalled {
+		t.Fatal("sendProxies was called after sendNode failed, want Drain to stop")
+	}
+
+	// Drain already pulled everything out of the outbox before
+	// attempting to send it, so a failed send doesn't leave anything
+	// behind to retry -- the next stats tick recollects fresh state
+	// instead.
+	proxiesCalledAgain := false
+	_ = o.Drain(
+		func(action string, data map[string]any) error { return nil },
+		func(stats []map[string]any) error {
+			proxiesCalledAgain = true
+			return nil
+		},
+	)
+	if proxiesCalledAgain {
+		t.Fatal("sendProxies was called on retry, want the already-extracted stats to be gone")
+	}
+}
+
+var errFakeSend = fakeSendError("fake send failure")
+
+type fakeSendError string
+
+func (e fakeSendError) Error() string { return string(e) }
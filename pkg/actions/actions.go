@@ -0,0 +1,44 @@
+// Package actions 实现面板下发动作在节点侧的分发
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Handler processes one server-issued action and returns the reply payload.
+type Handler func(ctx context.Context, data json.RawMessage) (any, error)
+
+// Registry dispatches an action by name to its registered Handler.
+type Registry struct {
+	handlers map[string]Handler
+}
+
+// NewRegistry 创建一个空的动作处理器注册表
+func NewRegistry() *Registry {
+	return &Registry{handlers: make(map[string]Handler)}
+}
+
+// Register adds a handler for the named action.
+func (r *Registry) Register(action string, handler Handler) {
+	r.handlers[action] = handler
+}
+
+// UnknownActionError is returned by Dispatch when no handler is registered for the action.
+type UnknownActionError struct {
+	Action string
+}
+
+func (e *UnknownActionError) Error() string {
+	return fmt.Sprintf("actions: unknown action %q", e.Action)
+}
+
+// Dispatch runs the handler registered for action, if any.
+func (r *Registry) Dispatch(ctx context.Context, action string, data json.RawMessage) (any, error) {
+	handler, ok := r.handlers[action]
+	if !ok {
+		return nil, &UnknownActionError{Action: action}
+	}
+	return handler(ctx, data)
+}
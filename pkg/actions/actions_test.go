@@ -0,0 +1,58 @@
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestDispatchRunsRegisteredHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Register("kick-proxy", func(_ context.Context, data json.RawMessage) (any, error) {
+		return string(data), nil
+	})
+
+	result, err := r.Dispatch(context.Background(), "kick-proxy", json.RawMessage(`{"proxy_name":"p1"}`))
+	if err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if result != `{"proxy_name":"p1"}` {
+		t.Fatalf("Dispatch() result = %v, want the raw data handed back", result)
+	}
+}
+
+func TestDispatchUnknownActionIsTyped(t *testing.T) {
+	r := NewRegistry()
+
+	_, err := r.Dispatch(context.Background(), "does-not-exist", nil)
+	if err == nil {
+		t.Fatal("Dispatch() error = nil, want an UnknownActionError")
+	}
+
+	var unknown *UnknownActionError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("Dispatch() error = %v (%T), want *UnknownActionError", err, err)
+	}
+	if unknown.Action != "does-not-exist" {
+		t.Fatalf("unknown.Action = %q, want %q", unknown.Action, "does-not-exist")
+	}
+}
+
+func TestRegisterOverwritesPreviousHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Register("restart-frps", func(_ context.Context, _ json.RawMessage) (any, error) {
+		return "first", nil
+	})
+	r.Register("restart-frps", func(_ context.Context, _ json.RawMessage) (any, error) {
+		return "second", nil
+	})
+
+	result, err := r.Dispatch(context.Background(), "restart-frps", nil)
+	if err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if result != "second" {
+		t.Fatalf("Dispatch() result = %v, want the most recently registered handler to win", result)
+	}
+}
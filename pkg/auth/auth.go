@@ -0,0 +1,139 @@
+// Package auth 负责用节点长期 API Key 换取短期 JWT 并保持续期
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"lcf-controller/logger"
+)
+
+// refreshMargin is how far ahead of a token's expiry it's refreshed.
+const refreshMargin = 30 * time.Second
+
+// retryDelay is how long Run waits before retrying a failed exchange.
+const retryDelay = 5 * time.Second
+
+// Claims mirrors the payload the server encodes into the JWT it issues.
+type Claims struct {
+	NodeId int    `json:"node_id"`
+	Iat    int64  `json:"iat"`
+	Exp    int64  `json:"exp"`
+	Nonce  string `json:"nonce"`
+}
+
+// Token is one JWT issued by the server, along with the key ID that signed it.
+type Token struct {
+	Value string
+	Kid   string
+	Exp   time.Time
+}
+
+// exchangeResponse is what the server returns for a token exchange.
+type exchangeResponse struct {
+	Token string `json:"token"`
+	Kid   string `json:"kid"`
+	Exp   int64  `json:"exp"`
+}
+
+// Manager exchanges an API key for a JWT and keeps it fresh in the background.
+type Manager struct {
+	authUrl string
+	nodeId  int
+	apiKey  string
+	client  *http.Client
+
+	mu    sync.RWMutex
+	token Token
+}
+
+// NewManager 创建一个负责获取与续期节点 JWT 的管理器
+func NewManager(authUrl string, nodeId int, apiKey string) *Manager {
+	return &Manager{
+		authUrl: authUrl,
+		nodeId:  nodeId,
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Current returns the most recently issued token without blocking.
+func (m *Manager) Current() Token {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.token
+}
+
+// Refresh exchanges the API key for a new JWT immediately.
+func (m *Manager) Refresh(ctx context.Context) error {
+	body, err := json.Marshal(map[string]any{
+		"node_id": m.nodeId,
+		"api_key": m.apiKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, m.authUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth: token exchange returned status %d", resp.StatusCode)
+	}
+
+	var out exchangeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	previousKid := m.token.Kid
+	m.token = Token{Value: out.Token, Kid: out.Kid, Exp: time.Unix(out.Exp, 0)}
+	m.mu.Unlock()
+
+	if previousKid != "" && out.Kid != previousKid {
+		logger.Info("node token signing key rotated", zap.String("previous_kid", previousKid), zap.String("kid", out.Kid))
+	}
+	return nil
+}
+
+// Run keeps the token fresh for as long as ctx is alive.
+func (m *Manager) Run(ctx context.Context) {
+	for {
+		wait := time.Duration(0)
+		if token := m.Current(); token.Value != "" {
+			if d := time.Until(token.Exp) - refreshMargin; d > 0 {
+				wait = d
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if err := m.Refresh(ctx); err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Package config loads the node's on-disk configuration.
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"lcf-controller/logger"
+)
+
+// configPathEnv lets operators point the node at a config file other
+// than the default, e.g. for running multiple nodes on one host.
+const configPathEnv = "LCF_CONTROLLER_CONFIG"
+
+// defaultConfigPath is where the node looks for its configuration when
+// configPathEnv isn't set.
+const defaultConfigPath = "/etc/lcf-controller/config.json"
+
+// Config is the root of the node's on-disk configuration.
+type Config struct {
+	ControllerConfig ControllerConfig `json:"controller"`
+	OpenGFWConfig    OpenGFWConfig    `json:"opengfw"`
+	MonitorConfig    MonitorConfig    `json:"monitor"`
+}
+
+// ControllerConfig configures the node's connection to the LoCyanFrp
+// panel.
+type ControllerConfig struct {
+	Enable       bool          `json:"enable"`
+	Addr         string        `json:"addr"`
+	NodeId       int           `json:"node_id"`
+	NodeApiKey   string        `json:"node_api_key"`
+	SendDuration time.Duration `json:"send_duration"`
+
+	// AuthUrl is the endpoint NodeApiKey is exchanged for a JWT against.
+	AuthUrl string `json:"auth_url"`
+
+	// TunnelAllowlist is the set of targets an open-tunnel action may reach.
+	TunnelAllowlist []TunnelAllowEntry `json:"tunnel_allowlist"`
+}
+
+// TunnelAllowEntry is one operator-approved tunnel target.
+type TunnelAllowEntry struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+// OpenGFWConfig controls the optional OpenGFW rule injector.
+type OpenGFWConfig struct {
+	Enable bool `json:"enable"`
+}
+
+// MonitorConfig controls the optional Akile monitor reporter.
+type MonitorConfig struct {
+	Enable bool `json:"enable"`
+}
+
+// ReadCfg 从配置文件加载节点配置，读取或解析失败时直接终止进程
+func ReadCfg() *Config {
+	path := os.Getenv(configPathEnv)
+	if path == "" {
+		path = defaultConfigPath
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		logger.Fatal("can't open config file: " + err.Error())
+		return nil
+	}
+	defer f.Close()
+
+	var cfg Config
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		logger.Fatal("can't parse config file: " + err.Error())
+		return nil
+	}
+	return &cfg
+}
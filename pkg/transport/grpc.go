@@ -0,0 +1,119 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+)
+
+// grpcDialTimeout bounds how long dialGRPC waits for the connection to
+// actually reach Ready, since grpc.NewClient dials lazily.
+const grpcDialTimeout = 10 * time.Second
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec lets the gRPC transport carry the same JSON envelope as the other transports.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+// nodeStreamMethod is the bidi-streaming RPC every node speaks to the panel.
+const nodeStreamMethod = "/lcf.controller.NodeStream/Connect"
+
+var nodeStreamDesc = grpc.StreamDesc{
+	StreamName:    "Connect",
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// grpcTransport speaks the control-plane envelope over a gRPC bidi-stream.
+type grpcTransport struct {
+	conn   *grpc.ClientConn
+	stream grpc.ClientStream
+
+	// writeMu serializes SendMsg calls: grpc.ClientStream forbids
+	// concurrent callers on the same stream.
+	writeMu sync.Mutex
+}
+
+// dialGRPC 建立一个 gRPC 双向流传输连接
+func dialGRPC(addr string) (Transport, error) {
+	secure := strings.HasPrefix(addr, "grpcs://")
+	target := strings.TrimPrefix(strings.TrimPrefix(addr, "grpcs://"), "grpc://")
+
+	var creds credentials.TransportCredentials
+	if secure {
+		creds = credentials.NewTLS(nil)
+	} else {
+		creds = insecure.NewCredentials()
+	}
+
+	conn, err := grpc.NewClient(target,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodec{}.Name())),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := waitReady(conn, target); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	stream, err := conn.NewStream(context.Background(), &nodeStreamDesc, nodeStreamMethod)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &grpcTransport{conn: conn, stream: stream}, nil
+}
+
+// waitReady blocks until conn reaches connectivity.Ready or grpcDialTimeout elapses.
+func waitReady(conn *grpc.ClientConn, target string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcDialTimeout)
+	defer cancel()
+
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("transport: gRPC connection to %s did not become ready within %s", target, grpcDialTimeout)
+		}
+	}
+}
+
+func (t *grpcTransport) Send(ctx context.Context, req Request) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.stream.SendMsg(&req)
+}
+
+func (t *grpcTransport) Recv(ctx context.Context) (Message, error) {
+	var msg Message
+	if err := t.stream.RecvMsg(&msg); err != nil {
+		return Message{}, err
+	}
+	return msg, nil
+}
+
+func (t *grpcTransport) Close() error {
+	return t.conn.Close()
+}
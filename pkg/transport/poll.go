@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// pollTimeout bounds how long a single long-poll GET waits.
+const pollTimeout = 30 * time.Second
+
+// pollTransport speaks the control-plane envelope over plain HTTPS long-polling.
+type pollTransport struct {
+	base   string
+	client *http.Client
+}
+
+// dialPoll 建立一个 HTTPS 长轮询传输连接
+func dialPoll(addr string) (Transport, error) {
+	base := strings.TrimPrefix(addr, "https+poll://")
+	return &pollTransport{
+		base:   "https://" + base,
+		client: &http.Client{Timeout: pollTimeout + 5*time.Second},
+	}, nil
+}
+
+func (t *pollTransport) Send(ctx context.Context, req Request) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, t.base+"/send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("transport: send returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Recv long-polls until a message is available, the context is
+// cancelled, or the poll fails.
+func (t *pollTransport) Recv(ctx context.Context) (Message, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		default:
+		}
+
+		msg, ok, err := t.pollOnce(ctx)
+		if err != nil {
+			return Message{}, err
+		}
+		if ok {
+			return msg, nil
+		}
+	}
+}
+
+// pollOnce issues a single long-poll GET.
+func (t *pollTransport) pollOnce(ctx context.Context) (Message, bool, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, t.base+"/poll", nil)
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	resp, err := t.client.Do(httpReq)
+	if err != nil {
+		return Message{}, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return Message{}, false, nil
+	}
+	if resp.StatusCode >= 300 {
+		return Message{}, false, fmt.Errorf("transport: poll returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	var msg Message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return Message{}, false, err
+	}
+	return msg, true, nil
+}
+
+func (t *pollTransport) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
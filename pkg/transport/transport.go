@@ -0,0 +1,53 @@
+// Package transport 定义控制面与面板通信所用的可插拔传输层
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Message is one control-plane frame received from the server.
+type Message struct {
+	Status          int             `json:"status"`
+	Action          string          `json:"action"`
+	Message         string          `json:"message"`
+	Data            json.RawMessage `json:"data"`
+	ProtocolVersion int             `json:"protocol_version"`
+	CorrelationId   string          `json:"correlation_id"`
+}
+
+// Request is one control-plane frame sent to the server.
+type Request struct {
+	Action string         `json:"action"`
+	Node   NodeInfo       `json:"node"`
+	Token  string         `json:"token"`
+	Data   map[string]any `json:"data"`
+}
+
+// NodeInfo identifies the sending node.
+type NodeInfo struct {
+	Id int `json:"id"`
+}
+
+// Transport is how the controller talks to the panel.
+type Transport interface {
+	Send(ctx context.Context, req Request) error
+	Recv(ctx context.Context) (Message, error)
+	Close() error
+}
+
+// Dial 根据地址协议选择对应的 Transport 实现
+func Dial(addr string) (Transport, error) {
+	switch {
+	case strings.HasPrefix(addr, "ws://"), strings.HasPrefix(addr, "wss://"):
+		return dialWS(addr)
+	case strings.HasPrefix(addr, "https+poll://"):
+		return dialPoll(addr)
+	case strings.HasPrefix(addr, "grpc://"), strings.HasPrefix(addr, "grpcs://"):
+		return dialGRPC(addr)
+	default:
+		return nil, fmt.Errorf("transport: unsupported address scheme in %q", addr)
+	}
+}
@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsPingInterval and wsPongWait control the WebSocket keepalive.
+const wsPingInterval = 30 * time.Second
+const wsPongWait = 45 * time.Second
+
+// wsWriteWait bounds a single WriteMessage call.
+const wsWriteWait = 10 * time.Second
+
+// wsTransport speaks the control-plane envelope over a gorilla
+// WebSocket connection, and also exposes ReadRaw/WriteRaw for the
+// tunnel subsystem's binary frames.
+type wsTransport struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	closeOnce sync.Once
+	pingDone  chan struct{}
+}
+
+// dialWS 建立一个 WebSocket 传输连接
+func dialWS(addr string) (Transport, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(addr, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &wsTransport{conn: conn, pingDone: make(chan struct{})}
+
+	_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	go t.pingLoop()
+	return t, nil
+}
+
+// pingLoop keeps the connection alive and detects half-open TCP.
+func (t *wsTransport) pingLoop() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.pingDone:
+			return
+		case <-ticker.C:
+			if err := t.WriteRaw(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (t *wsTransport) Send(ctx context.Context, req Request) error {
+	msg, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return t.WriteRaw(websocket.TextMessage, msg)
+}
+
+// Recv reads the next frame and decodes it as a control-plane Message.
+func (t *wsTransport) Recv(ctx context.Context) (Message, error) {
+	mt, data, err := t.ReadRaw()
+	if err != nil {
+		return Message{}, err
+	}
+	if mt != websocket.TextMessage {
+		return Message{}, nil
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return Message{}, err
+	}
+	return msg, nil
+}
+
+// ReadRaw exposes the underlying WebSocket message, type and all.
+func (t *wsTransport) ReadRaw() (messageType int, data []byte, err error) {
+	return t.conn.ReadMessage()
+}
+
+// WriteRaw writes a raw WebSocket message under writeMu.
+func (t *wsTransport) WriteRaw(messageType int, data []byte) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	if err := t.conn.SetWriteDeadline(time.Now().Add(wsWriteWait)); err != nil {
+		return err
+	}
+	return t.conn.WriteMessage(messageType, data)
+}
+
+func (t *wsTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.pingDone) })
+	return t.conn.Close()
+}
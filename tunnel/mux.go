@@ -0,0 +1,207 @@
+// Package tunnel 在单条出站 WebSocket 连接上承载反向 TCP/UDP 隧道
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Opcode identifies the kind of payload carried by a tunnel frame.
+type Opcode byte
+
+const (
+	OpData Opcode = iota
+	OpFIN
+)
+
+// frameHeaderLen is the [1B opcode][4B streamID] prefix on every frame.
+const frameHeaderLen = 5
+
+// dialTimeout bounds how long Open waits for the local target to accept
+// the connection, so a blackholed or merely down target can't hang the
+// caller past the action dispatcher's own timeout.
+const dialTimeout = 10 * time.Second
+
+// inboundQueueSize bounds how many ws->local chunks are queued per stream.
+const inboundQueueSize = 64
+
+// AllowEntry is one (host, port, protocol) triple an operator has whitelisted.
+type AllowEntry struct {
+	Host     string
+	Port     int
+	Protocol string
+}
+
+// Conn is the subset of the underlying transport a Mux needs to write frames.
+type Conn interface {
+	WriteRaw(messageType int, data []byte) error
+}
+
+// stream is one active tunnel's local connection and its inbound queue.
+type stream struct {
+	conn    net.Conn
+	inbound chan []byte
+}
+
+// Mux owns every active tunnel stream for one connection.
+type Mux struct {
+	conn Conn
+
+	allow []AllowEntry
+
+	mu      sync.Mutex
+	streams map[uint32]*stream
+}
+
+// NewMux 创建一个绑定到指定 WebSocket 连接的隧道复用器
+func NewMux(conn Conn, allow []AllowEntry) *Mux {
+	return &Mux{
+		conn:    conn,
+		allow:   allow,
+		streams: make(map[uint32]*stream),
+	}
+}
+
+func (m *Mux) isAllowed(protocol, host string, port int) bool {
+	for _, e := range m.allow {
+		if e.Protocol == protocol && e.Host == host && e.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// Open dials the requested target and starts bridging it onto streamID.
+func (m *Mux) Open(ctx context.Context, streamID uint32, protocol, host string, port int) error {
+	if !m.isAllowed(protocol, host, port) {
+		return fmt.Errorf("tunnel target %s://%s:%d is not in the allow-list", protocol, host, port)
+	}
+
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, protocol, fmt.Sprintf("%s:%d", host, port))
+	if err != nil {
+		return err
+	}
+
+	s := &stream{conn: conn, inbound: make(chan []byte, inboundQueueSize)}
+	m.mu.Lock()
+	if _, exists := m.streams[streamID]; exists {
+		m.mu.Unlock()
+		_ = conn.Close()
+		return fmt.Errorf("tunnel stream %d is already open", streamID)
+	}
+	m.streams[streamID] = s
+	m.mu.Unlock()
+
+	go m.pump(streamID, conn)
+	go m.deliver(streamID, s)
+	return nil
+}
+
+// Close tears down the stream identified by streamID, if it exists.
+func (m *Mux) Close(streamID uint32) {
+	m.mu.Lock()
+	s, ok := m.streams[streamID]
+	delete(m.streams, streamID)
+	m.mu.Unlock()
+	if ok {
+		_ = s.conn.Close()
+		close(s.inbound)
+	}
+}
+
+// CloseAll tears down every active stream, e.g. on WebSocket reconnect.
+func (m *Mux) CloseAll() {
+	m.mu.Lock()
+	streams := m.streams
+	m.streams = make(map[uint32]*stream)
+	m.mu.Unlock()
+
+	for _, s := range streams {
+		_ = s.conn.Close()
+		close(s.inbound)
+	}
+}
+
+// deliver writes frames queued for one stream to its local conn.
+func (m *Mux) deliver(streamID uint32, s *stream) {
+	for payload := range s.inbound {
+		if _, err := s.conn.Write(payload); err != nil {
+			m.Close(streamID)
+			return
+		}
+	}
+}
+
+// pump copies conn -> WebSocket as OpData frames until conn is closed
+// or a write fails, then removes the stream. A FIN is emitted only when
+// the read from conn ends; a failed write sends no FIN.
+func (m *Mux) pump(streamID uint32, conn net.Conn) {
+	defer m.Close(streamID)
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if werr := m.writeFrame(OpData, streamID, buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			_ = m.writeFrame(OpFIN, streamID, nil)
+			return
+		}
+	}
+}
+
+// HandleFrame decodes one binary WebSocket message and applies it to the matching local stream.
+func (m *Mux) HandleFrame(frame []byte) error {
+	if len(frame) < frameHeaderLen {
+		return fmt.Errorf("tunnel frame too short: %d bytes", len(frame))
+	}
+
+	opcode := Opcode(frame[0])
+	streamID := binary.BigEndian.Uint32(frame[1:frameHeaderLen])
+	payload := frame[frameHeaderLen:]
+
+	switch opcode {
+	case OpData:
+		m.mu.Lock()
+		s, ok := m.streams[streamID]
+		if !ok {
+			m.mu.Unlock()
+			return fmt.Errorf("tunnel data for unknown stream %d", streamID)
+		}
+		// Send under mu: Close/CloseAll delete the entry under mu too, so
+		// finding s here means it can't be closed out from under this send.
+		select {
+		case s.inbound <- payload:
+			m.mu.Unlock()
+			return nil
+		default:
+			m.mu.Unlock()
+			return fmt.Errorf("tunnel stream %d is backpressured, dropping %d bytes", streamID, len(payload))
+		}
+	case OpFIN:
+		m.Close(streamID)
+		return nil
+	default:
+		return fmt.Errorf("unknown tunnel opcode %d", opcode)
+	}
+}
+
+// writeFrame serializes and sends a single tunnel frame as a binary message.
+func (m *Mux) writeFrame(opcode Opcode, streamID uint32, payload []byte) error {
+	frame := make([]byte, frameHeaderLen+len(payload))
+	frame[0] = byte(opcode)
+	binary.BigEndian.PutUint32(frame[1:frameHeaderLen], streamID)
+	copy(frame[frameHeaderLen:], payload)
+
+	return m.conn.WriteRaw(websocket.BinaryMessage, frame)
+}
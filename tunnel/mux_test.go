@@ -0,0 +1,262 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConn records every frame written to it instead of touching a
+// real socket.
+type fakeConn struct {
+	mu     sync.Mutex
+	frames [][]byte
+}
+
+func (c *fakeConn) WriteRaw(_ int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	frame := make([]byte, len(data))
+	copy(frame, data)
+	c.frames = append(c.frames, frame)
+	return nil
+}
+
+func (c *fakeConn) last() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.frames) == 0 {
+		return nil
+	}
+	return c.frames[len(c.frames)-1]
+}
+
+func frame(opcode Opcode, streamID uint32, payload []byte) []byte {
+	f := make([]byte, frameHeaderLen+len(payload))
+	f[0] = byte(opcode)
+	binary.BigEndian.PutUint32(f[1:frameHeaderLen], streamID)
+	copy(f[frameHeaderLen:], payload)
+	return f
+}
+
+func TestIsAllowed(t *testing.T) {
+	m := NewMux(&fakeConn{}, []AllowEntry{{Host: "127.0.0.1", Port: 8080, Protocol: "tcp"}})
+
+	if !m.isAllowed("tcp", "127.0.0.1", 8080) {
+		t.Fatal("isAllowed() = false for a listed entry, want true")
+	}
+	if m.isAllowed("tcp", "127.0.0.1", 9090) {
+		t.Fatal("isAllowed() = true for an unlisted port, want false")
+	}
+	if m.isAllowed("udp", "127.0.0.1", 8080) {
+		t.Fatal("isAllowed() = true for an unlisted protocol, want false")
+	}
+}
+
+func TestOpenRejectsTargetNotOnAllowlist(t *testing.T) {
+	m := NewMux(&fakeConn{}, nil)
+
+	if err := m.Open(context.Background(), 1, "tcp", "127.0.0.1", 9); err == nil {
+		t.Fatal("Open() error = nil for a target not on the allow-list, want an error")
+	}
+}
+
+func TestOpenRespectsContext(t *testing.T) {
+	m := NewMux(&fakeConn{}, []AllowEntry{{Host: "127.0.0.1", Port: 9, Protocol: "tcp"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- m.Open(ctx, 1, "tcp", "127.0.0.1", 9) }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Open() error = nil for an already-cancelled context, want an error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Open() ignored its context and blocked instead of failing fast")
+	}
+}
+
+func TestHandleFrameBridgesDataToLocalConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 64)
+		n, _ := conn.Read(buf)
+		received <- buf[:n]
+	}()
+
+	m := NewMux(&fakeConn{}, []AllowEntry{{Host: "127.0.0.1", Port: addr.Port, Protocol: "tcp"}})
+	if err := m.Open(context.Background(), 1, "tcp", "127.0.0.1", addr.Port); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer m.CloseAll()
+
+	if err := m.HandleFrame(frame(OpData, 1, []byte("hello"))); err != nil {
+		t.Fatalf("HandleFrame() error = %v", err)
+	}
+
+	select {
+	case got := <-received:
+		if string(got) != "hello" {
+			t.Fatalf("local conn received %q, want %q", got, "hello")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the inbound queue to deliver the frame")
+	}
+}
+
+func TestHandleFrameDataForUnknownStreamErrors(t *testing.T) {
+	m := NewMux(&fakeConn{}, nil)
+
+	if err := m.HandleFrame(frame(OpData, 42, []byte("x"))); err == nil {
+		t.Fatal("HandleFrame() error = nil for an unknown stream, want an error")
+	}
+}
+
+func TestHandleFrameUnknownOpcodeErrors(t *testing.T) {
+	m := NewMux(&fakeConn{}, nil)
+
+	if err := m.HandleFrame([]byte{0xFF, 0, 0, 0, 1}); err == nil {
+		t.Fatal("HandleFrame() error = nil for an unknown opcode, want an error")
+	}
+}
+
+func TestHandleFrameTooShortErrors(t *testing.T) {
+	m := NewMux(&fakeConn{}, nil)
+
+	if err := m.HandleFrame([]byte{0, 0}); err == nil {
+		t.Fatal("HandleFrame() error = nil for a too-short frame, want an error")
+	}
+}
+
+func TestHandleFrameBackpressureDropsInsteadOfBlocking(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	defer ln.Close()
+	addr := ln.Addr().(*net.TCPAddr)
+
+	// Accept the connection but never read from it. The local socket
+	// buffer may still absorb these small writes, so the queue need not
+	// fill up; the test only checks that HandleFrame never blocks.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-make(chan struct{})
+	}()
+
+	m := NewMux(&fakeConn{}, []AllowEntry{{Host: "127.0.0.1", Port: addr.Port, Protocol: "tcp"}})
+	if err := m.Open(context.Background(), 1, "tcp", "127.0.0.1", addr.Port); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer m.CloseAll()
+
+	// None of these calls may block: HandleFrame must drop once the
+	// stream's queue is full rather than stall the caller (the shared
+	// read loop, in production).
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < inboundQueueSize*2; i++ {
+			_ = m.HandleFrame(frame(OpData, 1, []byte("x")))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("HandleFrame blocked instead of dropping once the inbound queue filled up")
+	}
+}
+
+func TestHandleFrameFINClosesStream(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	defer ln.Close()
+	addr := ln.Addr().(*net.TCPAddr)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	m := NewMux(&fakeConn{}, []AllowEntry{{Host: "127.0.0.1", Port: addr.Port, Protocol: "tcp"}})
+	if err := m.Open(context.Background(), 1, "tcp", "127.0.0.1", addr.Port); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	if err := m.HandleFrame(frame(OpFIN, 1, nil)); err != nil {
+		t.Fatalf("HandleFrame(OpFIN) error = %v", err)
+	}
+
+	if err := m.HandleFrame(frame(OpData, 1, []byte("x"))); err == nil {
+		t.Fatal("HandleFrame() error = nil for data on a stream closed by FIN, want an error")
+	}
+}
+
+func TestHandleFrameDataRacesWithConcurrentClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	defer ln.Close()
+	addr := ln.Addr().(*net.TCPAddr)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	m := NewMux(&fakeConn{}, []AllowEntry{{Host: "127.0.0.1", Port: addr.Port, Protocol: "tcp"}})
+
+	// Hammer HandleFrame(OpData) and Close on the same stream id from
+	// separate goroutines: HandleFrame must never send on an inbound
+	// channel that Close has already closed out from under it.
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		streamID := uint32(i)
+		if err := m.Open(context.Background(), streamID, "tcp", "127.0.0.1", addr.Port); err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = m.HandleFrame(frame(OpData, streamID, []byte("x")))
+		}()
+		go func() {
+			defer wg.Done()
+			m.Close(streamID)
+		}()
+	}
+	wg.Wait()
+}